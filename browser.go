@@ -0,0 +1,102 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"io/fs"
+	"net/http"
+	"time"
+)
+
+//go:embed static
+var staticFiles embed.FS
+
+// cacheStreamInterval is how often /debug/cache/stream pushes a fresh
+// CacheStats snapshot to connected clients.
+const cacheStreamInterval = 500 * time.Millisecond
+
+// registerBrowserRoutes wires the embedded single-page UI at / and the
+// routes it uses to drive navigation and inspect the cache. The SSE stream
+// and cache-snapshot routes expose internals, so they're gated behind the
+// same debug flag that guards /debug/cache.
+func (s *server) registerBrowserRoutes(router *http.ServeMux) {
+	sub, err := fs.Sub(staticFiles, "static")
+	if err != nil {
+		// staticFiles is embedded at build time, so a bad "static" path
+		// here would be a build-time programming error, not a runtime one.
+		panic(err)
+	}
+	router.Handle("/", http.FileServer(http.FS(sub)))
+
+	if s.debug {
+		router.HandleFunc("/debug/cache/stream", s.handleCacheStream())
+		router.HandleFunc("/debug/cache/snapshot", s.handleCacheSnapshot())
+	}
+}
+
+// cacheSlot describes one slot of the sliceCache for the browser UI's
+// heatmap. Slots are numbered positionally rather than by the fibonacci
+// index they hold, since multiple indices alias the same slot over time.
+type cacheSlot struct {
+	Slot     int    `json:"slot"`
+	Idx      uint32 `json:"idx"`
+	Occupied bool   `json:"occupied"`
+}
+
+// handler returning a point-in-time occupancy map of the sliceCache, used
+// to render the browser UI's cache heatmap
+func (s *server) handleCacheSnapshot() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		entries := s.fib.cache.Snapshot()
+		slots := make([]cacheSlot, len(entries))
+		for i, e := range entries {
+			// slot 0 is seeded with idx 0 at construction, so it's always
+			// occupied; every other slot starts zero-valued and only
+			// becomes occupied once Set is called for some real index.
+			occupied := i == 0 || e.idx != 0
+			slots[i] = cacheSlot{Slot: i, Idx: e.idx, Occupied: occupied}
+		}
+		if err := json.NewEncoder(w).Encode(slots); err != nil {
+			http.Error(w, "Server error", http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+// handler streaming CacheStats to the browser UI as Server-Sent Events so
+// the hit/miss counters update live instead of via polling
+func (s *server) handleCacheStream() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+
+		ticker := time.NewTicker(cacheStreamInterval)
+		defer ticker.Stop()
+
+		enc := json.NewEncoder(w)
+		for {
+			select {
+			case <-ticker.C:
+				if _, err := w.Write([]byte("data: ")); err != nil {
+					return
+				}
+				if err := enc.Encode(s.fib.CacheStats); err != nil {
+					return
+				}
+				if _, err := w.Write([]byte("\n")); err != nil {
+					return
+				}
+				flusher.Flush()
+			case <-r.Context().Done():
+				return
+			}
+		}
+	}
+}
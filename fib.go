@@ -3,8 +3,10 @@ package main
 import (
 	"errors"
 	"fmt"
+	"io"
 	"math"
 	"math/big"
+	"sort"
 	"sync"
 	"sync/atomic"
 )
@@ -12,15 +14,58 @@ import (
 // Struct for caching fibonacci numbers
 // The cache stores pairs of indices and their corresponding fibonacci values
 type FibTracker struct {
-	cachePad   uint32     // # of non-cached entries between each cached entry (ie caching interval)
-	CacheStats CacheStats // tracks cache hit/miss counts
-	cache      fibCache   // lru cache
+	cachePad     uint32     // # of non-cached entries between each cached entry (ie caching interval)
+	CacheStats   CacheStats // tracks cache hit/miss counts
+	cache        fibCache   // lru cache
+	regions      regionSet  // in-flight/computed spans, used to coalesce concurrent GetRange calls
+	algo         Algo       // algorithm Get uses to compute uncached values
+	maxRangeSpan uint32     // largest span GetRange will serve in one call; 0 means unbounded
+}
+
+// Algo selects the recurrence FibTracker.Get uses to compute a value that
+// isn't already in the cache.
+type Algo int
+
+const (
+	AlgoLinear       Algo = iota // calcFromPair: O(idx-pad) big-int adds
+	AlgoFastDoubling             // GetFast: O(log idx) big-int multiplications
+	AlgoAuto                     // pick per-call based on distance to the nearest cached pair
+)
+
+// autoFastDoublingThreshold is the distance from the nearest cached pair
+// beyond which AlgoAuto prefers fast doubling's O(log idx) multiplications
+// over the linear recurrence's O(distance) adds.
+const autoFastDoublingThreshold = 1000
+
+// ParseAlgo parses the --algo flag value, defaulting an empty string to
+// AlgoLinear (the behavior before this flag existed).
+func ParseAlgo(s string) (Algo, error) {
+	switch s {
+	case "", "linear":
+		return AlgoLinear, nil
+	case "fastdoubling":
+		return AlgoFastDoubling, nil
+	case "auto":
+		return AlgoAuto, nil
+	default:
+		return AlgoLinear, fmt.Errorf("unknown algo %q: want linear, fastdoubling, or auto", s)
+	}
 }
 
 // interface for a cache usable by the FibTracker
 type fibCache interface {
 	Get(uint32) (fibPair, error)
 	Set(uint32, fibPair) error
+	// Snapshot returns a point-in-time copy of every cache slot, in slot
+	// order, for inspection (eg the browser UI's cache heatmap).
+	Snapshot() []scEntry
+	// SaveSnapshot serializes every cache slot - length plus each entry's
+	// idx and pair - to w. See snapshot.go.
+	SaveSnapshot(w io.Writer) error
+	// LoadSnapshot replaces every cache slot with what SaveSnapshot wrote
+	// to r. It fails if the serialized length doesn't match this cache's
+	// size. See snapshot.go.
+	LoadSnapshot(r io.Reader) error
 }
 
 type CacheStats struct {
@@ -74,6 +119,18 @@ func (c *sliceCache) Set(idx uint32, pair fibPair) error {
 	return nil
 }
 
+// Snapshot returns a copy of every slot's entry, safe to read without
+// holding the cache's internal lock afterward.
+func (c *sliceCache) Snapshot() []scEntry {
+	scMux.Lock()
+	defer scMux.Unlock()
+	out := make([]scEntry, len(*c))
+	for i, e := range *c {
+		out[i] = scEntry{idx: e.idx, pair: fibPair{big.NewInt(0).Set(e.pair.i), big.NewInt(0).Set(e.pair.j)}}
+	}
+	return out
+}
+
 // stores fibonacci values for the ith and i+1th positions
 type fibPair struct {
 	i *big.Int // ith position
@@ -108,6 +165,22 @@ func (fib *FibTracker) WithInitializedStore(nInit uint32) *FibTracker {
 	return fib
 }
 
+// WithAlgo sets the algorithm Get uses to compute values not already in
+// the cache. The zero value (AlgoLinear) matches Get's original behavior.
+func (fib *FibTracker) WithAlgo(algo Algo) *FibTracker {
+	fib.algo = algo
+	return fib
+}
+
+// WithMaxRangeSpan bounds how many indices a single GetRange call may
+// serve; requests for a wider span are rejected instead of allocating a
+// []*big.Int of unbounded size. The zero value leaves GetRange unbounded,
+// matching its behavior before this existed.
+func (fib *FibTracker) WithMaxRangeSpan(n uint32) *FibTracker {
+	fib.maxRangeSpan = n
+	return fib
+}
+
 var basePair = fibPair{big.NewInt(0), big.NewInt(1)}
 
 // calculate fib number starting from 0 and 1
@@ -161,6 +234,10 @@ func (fib *FibTracker) printCache() {
 
 // get value at idx in fibonacci sequence
 func (fib *FibTracker) Get(idx uint32) *big.Int {
+	if fib.algo == AlgoFastDoubling || (fib.algo == AlgoAuto && fib.isFarFromCache(idx)) {
+		return fib.GetFast(idx)
+	}
+
 	// try to get cached value (for i or i+1)
 	if idx%fib.cachePad == 0 {
 		pair, err := fib.cache.Get(idx)
@@ -192,3 +269,445 @@ func (fib *FibTracker) Get(idx uint32) *big.Int {
 	fib.countMiss()
 	return fib.calcFromZero(idx)
 }
+
+// isFarFromCache reports whether idx is more than autoFastDoublingThreshold
+// past the nearest cached-at-or-below pair, the point past which
+// AlgoAuto prefers GetFast's O(log idx) multiplications over the linear
+// recurrence's O(distance) adds.
+func (fib *FibTracker) isFarFromCache(idx uint32) bool {
+	seedIdx, _, ok := fib.nearestCachedPairAtOrBelow(idx)
+	if !ok {
+		return idx > autoFastDoublingThreshold
+	}
+	return idx-seedIdx > autoFastDoublingThreshold
+}
+
+// nearestCachedPairAtOrBelow performs the same pad-aligned lookback Get
+// uses to find a cached starting point, so range computation can resume
+// from a cache hit instead of always starting from zero.
+func (fib *FibTracker) nearestCachedPairAtOrBelow(idx uint32) (uint32, fibPair, bool) {
+	closeIndex := fib.roundDownToPad(idx)
+	for i := 0; i < 10 && closeIndex <= idx; i += 1 {
+		pair, err := fib.cache.Get(closeIndex)
+		if err == nil {
+			return closeIndex, pair, true
+		}
+		closeIndex -= fib.cachePad
+	}
+	return 0, fibPair{}, false
+}
+
+// GetFast returns F(idx) via the fast-doubling recurrence - F(2k) =
+// F(k)*(2*F(k+1)-F(k)) and F(2k+1) = F(k)^2+F(k+1)^2 - walking the bits of
+// idx from MSB to LSB, giving O(log idx) big-int multiplications instead
+// of calcFromPair's O(idx) adds. It seeds from the nearest cached pad at
+// or below idx when one exists, jumping the gap via the identity
+// F(m+n) = F(m)*F(n+1) + F(m-1)*F(n), and writes back any pad-aligned
+// pairs it passes through along the way.
+func (fib *FibTracker) GetFast(idx uint32) *big.Int {
+	if idx%fib.cachePad == 0 {
+		if pair, err := fib.cache.Get(idx); err == nil {
+			fib.countHit()
+			return pair.i
+		}
+	} else if (idx+1)%fib.cachePad == 0 {
+		if pair, err := fib.cache.Get(idx + 1); err == nil {
+			fib.countHit()
+			return pair.j
+		}
+	}
+
+	// as stored in the cache, a pair keyed on m holds (F(m), F(m-1)) - see
+	// fibPair's doc comment - which is exactly (F(m), F(m-1)) needed below.
+	seedIdx, seedPair, ok := fib.nearestCachedPairAtOrBelow(idx)
+	var fm, fmMinus1 *big.Int
+	if ok {
+		fib.countClose()
+		fm, fmMinus1 = seedPair.i, seedPair.j
+	} else {
+		fib.countMiss()
+		// F(-1) = 1 by the recurrence (F(1) = F(0) + F(-1) = 0 + 1)
+		seedIdx, fm, fmMinus1 = 0, big.NewInt(0), big.NewInt(1)
+	}
+
+	return fib.fastDoubleFrom(seedIdx, fm, fmMinus1, idx-seedIdx)
+}
+
+// fastDoubleFrom computes F(seedIdx+offset) given (F(seedIdx), F(seedIdx-1))
+// = (fm, fmMinus1), walking the bits of offset from MSB to LSB and
+// maintaining the running pair (a, b) = (F(k), F(k+1)) for the
+// offset-local index k. At each step it also derives the corresponding
+// absolute pair (F(seedIdx+k), F(seedIdx+k+1)) via F(m+n) = F(m)*F(n+1) +
+// F(m-1)*F(n) and caches it if seedIdx+k lands on a pad boundary.
+func (fib *FibTracker) fastDoubleFrom(seedIdx uint32, fm, fmMinus1 *big.Int, offset uint32) *big.Int {
+	if offset == 0 {
+		return big.NewInt(0).Set(fm)
+	}
+
+	a, b := big.NewInt(0), big.NewInt(1)
+	k := uint32(0)
+	started := false
+	for bit := 31; bit >= 0; bit-- {
+		if !started {
+			if offset&(1<<uint(bit)) == 0 {
+				continue
+			}
+			started = true
+		}
+
+		// double: k -> 2k
+		twoBMinusA := new(big.Int).Sub(new(big.Int).Lsh(b, 1), a)
+		c := new(big.Int).Mul(a, twoBMinusA)
+		d := new(big.Int).Add(new(big.Int).Mul(a, a), new(big.Int).Mul(b, b))
+		a, b = c, d
+		k *= 2
+		fib.cacheAbsolutePair(seedIdx, fm, fmMinus1, k, a, b)
+
+		if offset&(1<<uint(bit)) != 0 {
+			// advance: k -> k+1
+			a, b = b, new(big.Int).Add(a, b)
+			k++
+			fib.cacheAbsolutePair(seedIdx, fm, fmMinus1, k, a, b)
+		}
+	}
+
+	return combineFib(fm, fmMinus1, a, b)
+}
+
+// combineFib applies F(m+n) = F(m)*F(n+1) + F(m-1)*F(n) where (fm,
+// fmMinus1) = (F(m), F(m-1)) and (a, b) = (F(n), F(n+1)), yielding F(m+n).
+func combineFib(fm, fmMinus1, a, b *big.Int) *big.Int {
+	term1 := new(big.Int).Mul(fm, b)
+	term2 := new(big.Int).Mul(fmMinus1, a)
+	return term1.Add(term1, term2)
+}
+
+// combineFibPrev computes F(m+n-1), one step behind combineFib, using
+// F(n-1) = F(n+1) - F(n) = b-a so no extra recurrence step is needed.
+func combineFibPrev(fm, fmMinus1, a, b *big.Int) *big.Int {
+	term1 := new(big.Int).Mul(fm, a)
+	term2 := new(big.Int).Mul(fmMinus1, new(big.Int).Sub(b, a))
+	return term1.Add(term1, term2)
+}
+
+// cacheAbsolutePair writes (F(seedIdx+k), F(seedIdx+k-1)) into the cache -
+// matching the (F(m), F(m-1)) convention fibPair already uses elsewhere -
+// if seedIdx+k lands on a pad boundary, using the seed pair (fm, fmMinus1)
+// and the offset-local pair (a, b) to derive both values without
+// recomputing the whole recurrence.
+func (fib *FibTracker) cacheAbsolutePair(seedIdx uint32, fm, fmMinus1 *big.Int, k uint32, a, b *big.Int) {
+	actual := seedIdx + k
+	if actual == 0 || actual%fib.cachePad != 0 {
+		return
+	}
+	fib.cache.Set(actual, fibPair{combineFib(fm, fmMinus1, a, b), combineFibPrev(fm, fmMinus1, a, b)})
+}
+
+// region is a contiguous, inclusive span [a, e] of the fibonacci sequence
+// that's either being computed or has already been computed and is kept
+// around in memory so overlapping GetRange calls can attach to it instead
+// of redoing the work.
+type region struct {
+	a, e   uint32
+	values []*big.Int // values[i] == F(a+i), valid once ready is closed
+	ready  chan struct{}
+}
+
+func newRegion(a, e uint32) *region {
+	return &region{a: a, e: e, ready: make(chan struct{})}
+}
+
+func (r *region) wait() {
+	<-r.ready
+}
+
+func (r *region) isReady() bool {
+	select {
+	case <-r.ready:
+		return true
+	default:
+		return false
+	}
+}
+
+// slice returns the values for [from, to], which must be contained in r.
+func (r *region) slice(from, to uint32) []*big.Int {
+	return r.values[from-r.a : to-r.a+1]
+}
+
+// maxTotalRegionSpan bounds how many fibonacci values a regionSet keeps
+// resident across every region it tracks, combined. Without this, a client
+// can grow memory unboundedly by requesting many small, disjoint ranges -
+// each individually under GetRange's per-request cap, but never evicted.
+const maxTotalRegionSpan = 2_000_000
+
+// regionSet is a sorted, non-overlapping set of regions tracking recent and
+// in-flight GetRange work for a FibTracker.
+type regionSet struct {
+	mu        sync.Mutex
+	regions   []*region // sorted ascending by a
+	order     []*region // oldest-first insertion order, used to pick eviction victims
+	totalSpan uint64    // sum of spans currently in regions
+}
+
+// findCovering returns an existing region - ready or still being computed -
+// that fully contains [from, to], or nil if none does.
+func (rs *regionSet) findCovering(from, to uint32) *region {
+	for _, r := range rs.regions {
+		if r.a <= from && to <= r.e {
+			return r
+		}
+	}
+	return nil
+}
+
+// claim splits [from, to] against the regions already tracked, returning
+// every region (pre-existing or newly claimed) needed to answer the full
+// range, in order, plus just the newly claimed ones the caller must
+// compute. New regions are inserted into the set immediately so concurrent
+// callers see them and can coalesce rather than re-claim the same gap.
+func (rs *regionSet) claim(from, to uint32) (needed, claimed []*region) {
+	var overlapping []*region
+	for _, r := range rs.regions {
+		if r.e < from || r.a > to {
+			continue
+		}
+		overlapping = append(overlapping, r)
+	}
+
+	next := from
+	for _, r := range overlapping {
+		if r.a > next {
+			g := newRegion(next, r.a-1)
+			claimed = append(claimed, g)
+			needed = append(needed, g)
+		}
+		needed = append(needed, r)
+		if r.e+1 > next {
+			next = r.e + 1
+		}
+	}
+	if next <= to {
+		g := newRegion(next, to)
+		claimed = append(claimed, g)
+		needed = append(needed, g)
+	}
+
+	for _, g := range claimed {
+		rs.insert(g)
+	}
+	// protect claimed: it hasn't been computed yet, so evicting it here
+	// would silently defeat coalescing for this very request (a racing
+	// caller wanting the same range wouldn't find it via findCovering).
+	rs.evictLocked(claimed)
+	return needed, claimed
+}
+
+func regionSpan(r *region) uint64 {
+	return uint64(r.e) - uint64(r.a) + 1
+}
+
+func (rs *regionSet) insert(r *region) {
+	i := sort.Search(len(rs.regions), func(i int) bool { return rs.regions[i].a > r.a })
+	rs.regions = append(rs.regions, nil)
+	copy(rs.regions[i+1:], rs.regions[i:])
+	rs.regions[i] = r
+	rs.order = append(rs.order, r)
+	rs.totalSpan += regionSpan(r)
+}
+
+// remove drops r from regions if still present, reporting whether it was
+// found. It's a no-op otherwise - r may already have been evicted or
+// merged away by a racing caller.
+func (rs *regionSet) remove(r *region) bool {
+	for i, existing := range rs.regions {
+		if existing == r {
+			rs.regions = append(rs.regions[:i], rs.regions[i+1:]...)
+			rs.totalSpan -= regionSpan(r)
+			return true
+		}
+	}
+	return false
+}
+
+// evictLocked drops the oldest tracked regions, skipping anything in
+// protect, until totalSpan is back within maxTotalRegionSpan or only
+// protected regions remain. Callers must hold mu. Evicting a region that's
+// still being computed is otherwise safe: mergeComputed already no-ops if
+// its region has disappeared from the set by the time it finishes, and any
+// goroutine already waiting on a region holds a direct pointer to it
+// rather than looking it up through the set.
+func (rs *regionSet) evictLocked(protect []*region) {
+	i := 0
+	for rs.totalSpan > maxTotalRegionSpan && i < len(rs.order) {
+		victim := rs.order[i]
+		if containsRegion(protect, victim) {
+			i++
+			continue
+		}
+		rs.order = append(rs.order[:i], rs.order[i+1:]...)
+		rs.remove(victim)
+	}
+}
+
+// mergeComputed squashes r with any now-ready neighbor it touches or
+// overlaps (newReq.b <= existing.e+1), keeping the set compact so future
+// requests coalesce against fewer, larger regions instead of many slivers.
+func (rs *regionSet) mergeComputed(r *region) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	i := -1
+	for idx, existing := range rs.regions {
+		if existing == r {
+			i = idx
+			break
+		}
+	}
+	if i < 0 {
+		return // already squashed away by a racing merge
+	}
+
+	merged := false
+	if i > 0 {
+		if left := rs.regions[i-1]; left.isReady() && left.e+1 >= r.a {
+			rs.totalSpan -= regionSpan(left) + regionSpan(r)
+			r = combineRegions(left, r)
+			rs.regions = append(rs.regions[:i-1], rs.regions[i:]...)
+			i--
+			rs.regions[i] = r
+			rs.totalSpan += regionSpan(r)
+			merged = true
+		}
+	}
+	if i+1 < len(rs.regions) {
+		if right := rs.regions[i+1]; right.isReady() && r.e+1 >= right.a {
+			rs.totalSpan -= regionSpan(r) + regionSpan(right)
+			r = combineRegions(r, right)
+			rs.regions = append(rs.regions[:i+1], rs.regions[i+2:]...)
+			rs.regions[i] = r
+			rs.totalSpan += regionSpan(r)
+			merged = true
+		}
+	}
+	if merged {
+		rs.order = append(rs.order, r)
+	}
+	rs.evictLocked(nil)
+}
+
+// combineRegions merges two ready, adjacent-or-overlapping regions
+// (left.a <= right.a) into a single ready region spanning both.
+func combineRegions(left, right *region) *region {
+	a := left.a
+	e := left.e
+	if right.e > e {
+		e = right.e
+	}
+	values := make([]*big.Int, e-a+1)
+	copy(values, left.values)
+	copy(values[right.a-a:], right.values)
+
+	merged := &region{a: a, e: e, values: values, ready: make(chan struct{})}
+	close(merged.ready)
+	return merged
+}
+
+func maxU32(a, b uint32) uint32 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func minU32(a, b uint32) uint32 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func containsRegion(regions []*region, r *region) bool {
+	for _, existing := range regions {
+		if existing == r {
+			return true
+		}
+	}
+	return false
+}
+
+// computeRegion fills g.values for every index in [g.a, g.e], resuming the
+// big-int recurrence from the nearest cached pad at or below g.a so a
+// range of length N costs O(N) big-int adds rather than O(N+g.a), then
+// closes g.ready so waiters can read the result.
+func (fib *FibTracker) computeRegion(g *region) {
+	pairIdx, pair, ok := fib.nearestCachedPairAtOrBelow(g.a)
+	var n1, n2 *big.Int
+	if ok {
+		fib.countClose()
+		n1 = big.NewInt(0).Set(pair.i)
+		n2 = big.NewInt(0).Set(pair.j)
+	} else {
+		fib.countMiss()
+		pairIdx = 0
+		n1 = big.NewInt(0)
+		n2 = big.NewInt(1)
+	}
+
+	g.values = make([]*big.Int, g.e-g.a+1)
+	if pairIdx >= g.a {
+		g.values[pairIdx-g.a] = big.NewInt(0).Set(n1)
+	}
+
+	for i := pairIdx + 1; i < g.e+1; i++ {
+		n2.Add(n2, n1)
+		n1, n2 = n2, n1
+		if i%fib.cachePad == 0 {
+			fib.cache.Set(i, fibPair{n1, n2})
+		}
+		if i >= g.a {
+			g.values[i-g.a] = big.NewInt(0).Set(n1)
+		}
+	}
+	close(g.ready)
+}
+
+// GetRange returns F(from), ..., F(to) inclusive. Concurrent, overlapping
+// calls coalesce: a request attaches to any in-flight or already-computed
+// region that covers the part it needs instead of recomputing it, and only
+// genuinely uncovered sub-ranges are computed from scratch.
+func (fib *FibTracker) GetRange(from, to uint32) ([]*big.Int, error) {
+	if from > to {
+		return nil, fmt.Errorf("invalid range [%v, %v]: from must be <= to", from, to)
+	}
+	span := uint64(to) - uint64(from) + 1
+	if fib.maxRangeSpan > 0 && span > uint64(fib.maxRangeSpan) {
+		return nil, fmt.Errorf("range [%v, %v] spans %v indices, which exceeds the maximum of %v", from, to, span, fib.maxRangeSpan)
+	}
+
+	fib.regions.mu.Lock()
+	if covering := fib.regions.findCovering(from, to); covering != nil {
+		fib.regions.mu.Unlock()
+		covering.wait()
+		fib.countHit()
+		return covering.slice(from, to), nil
+	}
+	needed, claimed := fib.regions.claim(from, to)
+	fib.regions.mu.Unlock()
+
+	for _, g := range claimed {
+		fib.computeRegion(g)
+		fib.regions.mergeComputed(g)
+	}
+
+	result := make([]*big.Int, to-from+1)
+	for _, r := range needed {
+		if !containsRegion(claimed, r) {
+			r.wait()
+			fib.countHit()
+		}
+		lo, hi := maxU32(from, r.a), minU32(to, r.e)
+		copy(result[lo-from:hi-from+1], r.slice(lo, hi))
+	}
+	return result, nil
+}
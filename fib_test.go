@@ -1,8 +1,11 @@
 package main
 
 import (
+	"bytes"
 	"math/big"
+	"path/filepath"
 	"strconv"
+	"sync"
 	"testing"
 )
 
@@ -90,6 +93,348 @@ func TestGet(t *testing.T) {
 	}
 }
 
+func TestGetFast(t *testing.T) {
+	testCachePad := uint32(10)
+	hc, err := MakeSliceCache(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fib := MakeFibTracker(int(testCachePad), hc)
+	for _, tt := range fibTests {
+		t.Run(strconv.Itoa(int(tt.index)), func(t *testing.T) {
+			val := fib.GetFast(tt.index)
+			if val.String() != tt.expected {
+				t.Fatalf("Expected (%v) Got (%v)", tt.expected, val)
+			}
+
+			// if our index was in the right interval, it should be cached with correct values
+			if tt.index != 0 && tt.index%testCachePad == 0 {
+				pair, err := fib.cache.Get(tt.index)
+				if err != nil {
+					t.Fatalf("Expected (idx %v to be in cache) Got (not in cache)", tt.index)
+				}
+				if pair.i.String() != tt.expected {
+					t.Fatalf("Expected (%v) Got (%v)", tt.expected, pair.i)
+				}
+			}
+		})
+	}
+}
+
+// TestGetFastSeeded checks GetFast jumps from a pre-warmed cache entry
+// instead of always starting from zero, and still lands on the right value.
+func TestGetFastSeeded(t *testing.T) {
+	hc, err := MakeSliceCache(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fib := MakeFibTracker(10, hc)
+	fib.Get(500) // warm the cache up to idx 500
+
+	const expected = "135146142345092186703752249180774384275764289333345966720120142869985595050503649670814071604682819066479121454562"
+	statsBefore := fib.CacheStats
+	val := fib.GetFast(543)
+	if val.String() != expected {
+		t.Fatalf("Expected (%v) Got (%v)", expected, val)
+	}
+	if fib.CacheStats.NCloseHit != statsBefore.NCloseHit+1 {
+		t.Fatalf("expected GetFast to resume from the warmed cache instead of starting from zero")
+	}
+}
+
+func TestGetAuto(t *testing.T) {
+	hc, err := MakeSliceCache(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fib := MakeFibTracker(10, hc).WithAlgo(AlgoAuto)
+	for _, tt := range fibTests {
+		val := fib.Get(tt.index)
+		if val.String() != tt.expected {
+			t.Fatalf("index %v: Expected (%v) Got (%v)", tt.index, tt.expected, val)
+		}
+	}
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	hc, err := MakeSliceCache(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fib := MakeFibTracker(10, hc)
+	// warm the cache past several pad boundaries
+	fib.Get(543)
+
+	var buf bytes.Buffer
+	if err := fib.cache.SaveSnapshot(&buf); err != nil {
+		t.Fatalf("SaveSnapshot: %v", err)
+	}
+	snapshot := buf.Bytes()
+
+	// mutate the live cache so reloading is the only way to recover it
+	for _, idx := range []uint32{0, 10, 540, 550} {
+		if err := fib.cache.Set(idx, fibPair{big.NewInt(-1), big.NewInt(-1)}); err != nil {
+			t.Fatalf("Set(%v): %v", idx, err)
+		}
+	}
+
+	if err := fib.cache.LoadSnapshot(bytes.NewReader(snapshot)); err != nil {
+		t.Fatalf("LoadSnapshot: %v", err)
+	}
+
+	// indices spanning pad boundaries (idx%10==0), just below a boundary,
+	// and past the warmed range (recomputed from the restored cache)
+	for _, idx := range []uint32{0, 10, 20, 9, 19, 540, 543, 549, 1000} {
+		got := fib.Get(idx)
+		want := calcFibRef(idx)
+		if got.String() != want {
+			t.Fatalf("index %v: Expected (%v) Got (%v)", idx, want, got)
+		}
+	}
+}
+
+func TestFibTrackerSnapshotFileRoundTrip(t *testing.T) {
+	hc, err := MakeSliceCache(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fib := MakeFibTracker(10, hc)
+	fib.Get(543)
+
+	path := filepath.Join(t.TempDir(), "snapshot")
+	if err := fib.SaveSnapshot(path); err != nil {
+		t.Fatalf("SaveSnapshot: %v", err)
+	}
+
+	hc2, err := MakeSliceCache(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fib2 := MakeFibTracker(10, hc2)
+	ok, err := fib2.LoadSnapshot(path)
+	if err != nil {
+		t.Fatalf("LoadSnapshot: %v", err)
+	}
+	if !ok {
+		t.Fatal("LoadSnapshot: expected ok=true")
+	}
+
+	for _, idx := range []uint32{0, 10, 540, 543} {
+		got, want := fib2.Get(idx), fib.Get(idx)
+		if got.String() != want.String() {
+			t.Fatalf("index %v: Expected (%v) Got (%v)", idx, want, got)
+		}
+	}
+}
+
+func TestFibTrackerLoadSnapshotMissing(t *testing.T) {
+	hc, err := MakeSliceCache(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fib := MakeFibTracker(10, hc)
+	ok, err := fib.LoadSnapshot(filepath.Join(t.TempDir(), "does-not-exist"))
+	if err != nil {
+		t.Fatalf("LoadSnapshot: %v", err)
+	}
+	if ok {
+		t.Fatal("LoadSnapshot: expected ok=false for a missing file")
+	}
+}
+
+func TestFibTrackerLoadSnapshotPadMismatch(t *testing.T) {
+	hc, err := MakeSliceCache(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fib := MakeFibTracker(10, hc)
+	path := filepath.Join(t.TempDir(), "snapshot")
+	if err := fib.SaveSnapshot(path); err != nil {
+		t.Fatalf("SaveSnapshot: %v", err)
+	}
+
+	hc2, err := MakeSliceCache(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fib2 := MakeFibTracker(20, hc2)
+	ok, err := fib2.LoadSnapshot(path)
+	if err != nil {
+		t.Fatalf("LoadSnapshot: %v", err)
+	}
+	if ok {
+		t.Fatal("LoadSnapshot: expected ok=false for a cachePad mismatch")
+	}
+}
+
+// calcFibRef computes F(idx) directly, independent of any FibTracker cache,
+// for verifying values recovered from a snapshot.
+func calcFibRef(idx uint32) string {
+	a, b := big.NewInt(0), big.NewInt(1)
+	for i := uint32(0); i < idx; i++ {
+		a, b = b, new(big.Int).Add(a, b)
+	}
+	return a.String()
+}
+
+func TestGetRange(t *testing.T) {
+	hc, err := MakeSliceCache(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fib := MakeFibTracker(10, hc)
+
+	vals, err := fib.GetRange(0, 15)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vals) != 16 {
+		t.Fatalf("Expected (16 values) Got (%v)", len(vals))
+	}
+	for i, tt := range fibTests {
+		if tt.index > 15 {
+			break
+		}
+		if vals[i].String() != tt.expected {
+			t.Fatalf("index %v: Expected (%v) Got (%v)", tt.index, tt.expected, vals[i])
+		}
+	}
+
+	// an overlapping range should reuse what's already in the region set
+	// rather than recomputing values the first call already produced
+	vals, err = fib.GetRange(10, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 10; i <= 20; i++ {
+		expected := fib.Get(uint32(i))
+		if vals[i-10].String() != expected.String() {
+			t.Fatalf("index %v: Expected (%v) Got (%v)", i, expected, vals[i-10])
+		}
+	}
+
+	if _, err := fib.GetRange(5, 2); err == nil {
+		t.Fatal("Expected error for from > to, got nil")
+	}
+}
+
+// TestGetRangeMaxSpan checks that WithMaxRangeSpan rejects ranges wider
+// than the configured cap instead of allocating an unbounded []*big.Int.
+func TestGetRangeMaxSpan(t *testing.T) {
+	hc, err := MakeSliceCache(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fib := MakeFibTracker(10, hc).WithMaxRangeSpan(5)
+
+	if _, err := fib.GetRange(0, 4); err != nil {
+		t.Fatalf("expected span of 5 to be allowed, got err: %v", err)
+	}
+	if _, err := fib.GetRange(0, 5); err == nil {
+		t.Fatal("expected span of 6 to exceed the cap and be rejected")
+	}
+}
+
+// TestRegionSetEviction checks that the region set drops the oldest regions
+// once their combined span exceeds maxTotalRegionSpan, so a client can't
+// grow memory unboundedly by requesting many small, disjoint ranges. It
+// inserts synthetic, already-ready regions directly rather than going
+// through GetRange, so it doesn't have to actually compute huge spans of
+// fibonacci values.
+func TestRegionSetEviction(t *testing.T) {
+	var rs regionSet
+	regionSize := uint32(maxTotalRegionSpan / 10)
+
+	var first *region
+	for i := 0; i < 15; i++ {
+		a := uint32(i) * regionSize
+		r := newRegion(a, a+regionSize-1)
+		close(r.ready)
+		if i == 0 {
+			first = r
+		}
+		rs.mu.Lock()
+		rs.insert(r)
+		rs.evictLocked(nil)
+		rs.mu.Unlock()
+	}
+
+	if rs.totalSpan > maxTotalRegionSpan {
+		t.Fatalf("expected eviction to keep totalSpan <= %v, got %v", maxTotalRegionSpan, rs.totalSpan)
+	}
+	if rs.findCovering(first.a, first.e) != nil {
+		t.Fatal("expected the oldest region to have been evicted")
+	}
+}
+
+// TestRegionSetClaimProtectsItsOwnRegions checks that claim doesn't let
+// eviction drop the very regions it just inserted, even when they alone
+// exceed maxTotalRegionSpan (eg with --max-range-span=0, "unbounded").
+// Otherwise a legitimately-claimed region would be evicted before it's
+// even computed, silently defeating coalescing for that request.
+func TestRegionSetClaimProtectsItsOwnRegions(t *testing.T) {
+	var rs regionSet
+
+	_, claimed := rs.claim(0, maxTotalRegionSpan) // span exceeds the cap alone
+	if len(claimed) != 1 {
+		t.Fatalf("expected exactly one claimed region, got %v", len(claimed))
+	}
+	if rs.findCovering(0, maxTotalRegionSpan) == nil {
+		t.Fatal("expected claim's own region to survive its own eviction pass")
+	}
+}
+
+// TestGetRangeConcurrentCoalescing fires many overlapping ranges at once and
+// checks the resulting values are all correct and that coalescing kept the
+// total cache work well under one miss/close-hit per request.
+func TestGetRangeConcurrentCoalescing(t *testing.T) {
+	hc, err := MakeSliceCache(1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fib := MakeFibTracker(10, hc)
+
+	// compute expected values with an independent tracker so the
+	// concurrent GetRange calls below are the only thing touching fib.CacheStats
+	refCache, err := MakeSliceCache(1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref := MakeFibTracker(10, refCache)
+
+	const nRequests = 50
+	var wg sync.WaitGroup
+	wg.Add(nRequests)
+	for i := 0; i < nRequests; i++ {
+		from := uint32(i % 20)
+		to := from + 30
+		go func(from, to uint32) {
+			defer wg.Done()
+			vals, err := fib.GetRange(from, to)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			for j, v := range vals {
+				expected := ref.Get(from + uint32(j))
+				if v.String() != expected.String() {
+					t.Errorf("index %v: Expected (%v) Got (%v)", from+uint32(j), expected, v)
+				}
+			}
+		}(from, to)
+	}
+	wg.Wait()
+
+	// NMiss+NCloseHit count actual big-int computations (one per claimed
+	// region); NDirectHit counts requests that coalesced onto work someone
+	// else already did. Coalescing should mean far fewer computations than
+	// requests, since the ranges heavily overlap.
+	computed := fib.CacheStats.NMiss + fib.CacheStats.NCloseHit
+	if computed >= nRequests {
+		t.Fatalf("expected coalescing to keep computed regions (%v) below one per request (%v)", computed, nRequests)
+	}
+}
+
 // used to prevent complier from optimizing out result
 var result *big.Int
 
@@ -126,3 +471,40 @@ func BenchmarkSetNext10000(b *testing.B) {
 func BenchmarkSetNext100000(b *testing.B) {
 	benchmarkSetNextN(100000, b)
 }
+
+// benchmarkGetRangeOverlapping fires nRequests overlapping GetRange calls
+// concurrently and reports the resulting cache work as a custom metric, so
+// a regression in region coalescing shows up as a jump in "cache-ops/op".
+func benchmarkGetRangeOverlapping(nRequests int, b *testing.B) {
+	for n := 0; n < b.N; n++ {
+		hc, err := MakeSliceCache(1000)
+		if err != nil {
+			b.Fatal(err)
+		}
+		fib := MakeFibTracker(10, hc)
+
+		var wg sync.WaitGroup
+		wg.Add(nRequests)
+		for i := 0; i < nRequests; i++ {
+			from := uint32(i % 20)
+			go func(from uint32) {
+				defer wg.Done()
+				if _, err := fib.GetRange(from, from+30); err != nil {
+					b.Error(err)
+				}
+			}(from)
+		}
+		wg.Wait()
+
+		ops := fib.CacheStats.NDirectHit + fib.CacheStats.NCloseHit + fib.CacheStats.NMiss
+		b.ReportMetric(float64(ops)/float64(nRequests), "cache-ops/req")
+	}
+}
+
+func BenchmarkGetRangeOverlapping50(b *testing.B) {
+	benchmarkGetRangeOverlapping(50, b)
+}
+
+func BenchmarkGetRangeOverlapping500(b *testing.B) {
+	benchmarkGetRangeOverlapping(500, b)
+}
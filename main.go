@@ -1,16 +1,19 @@
 package main
 
 import (
-	"encoding/binary"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"net/http/pprof"
-	"os"
+	"net/url"
+	"strconv"
+	"sync"
 	"sync/atomic"
 	"time"
+
+	"github.com/macintoshpie/fibapi/wal"
 )
 
 type response struct {
@@ -20,21 +23,41 @@ type response struct {
 
 type server struct {
 	currentIndex uint32
-	fib          *FibTracker
-	debug        bool
-	backup       *os.File
+	// mu serializes currentIndex mutation with the WAL append and
+	// replication publish for that mutation, so two concurrent /next,
+	// /previous, or /seek requests can't append their WAL records in an
+	// order different from the order they updated currentIndex in -
+	// otherwise the WAL's last record (by seq) wouldn't necessarily hold
+	// the true final currentIndex, breaking crash recovery and follower
+	// ordering alike.
+	mu       sync.Mutex
+	fib      *FibTracker
+	debug    bool
+	browser  bool
+	wl       *wal.WAL
+	walDir   string
+	follower bool
+	replHub  *replicationHub
 }
 
 // Make server for fibonacci api
-func makeServer(fib *FibTracker, backup *os.File, debug bool) *server {
-	s := &server{0, fib, debug, backup}
-	return s
+func makeServer(fib *FibTracker, wl *wal.WAL, walDir string, debug bool, browser bool, follower bool) *server {
+	return &server{
+		currentIndex: 0,
+		fib:          fib,
+		debug:        debug,
+		browser:      browser,
+		wl:           wl,
+		walDir:       walDir,
+		follower:     follower,
+		replHub:      newReplicationHub(),
+	}
 }
 
 // handler for requests for current fibonacci value
 func (s *server) handleGetCurrent() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		idx := s.currentIndex
+		idx := atomic.LoadUint32(&s.currentIndex)
 		resp := response{idx, s.fib.Get(idx).String()}
 		responseEncoder := json.NewEncoder(w)
 		if err := responseEncoder.Encode(resp); err != nil {
@@ -47,7 +70,10 @@ func (s *server) handleGetCurrent() http.HandlerFunc {
 // handler for requests for next fibonacci value - increments sequence index and returns value
 func (s *server) handleSetNext() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
 		idx := atomic.AddUint32(&s.currentIndex, 1)
+		s.appendAndPublish(wal.OpNext, idx)
+		s.mu.Unlock()
 		resp := response{idx, s.fib.Get(idx).String()}
 		responseEncoder := json.NewEncoder(w)
 		if err := responseEncoder.Encode(resp); err != nil {
@@ -60,10 +86,13 @@ func (s *server) handleSetNext() http.HandlerFunc {
 // handler for requests for previous fibonacci value - decrements sequence index and returns value
 func (s *server) handleSetPrevious() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
 		idx := uint32(0)
-		if s.currentIndex > 0 {
+		if atomic.LoadUint32(&s.currentIndex) > 0 {
 			idx = atomic.AddUint32(&s.currentIndex, ^uint32(0))
 		}
+		s.appendAndPublish(wal.OpPrevious, idx)
+		s.mu.Unlock()
 		resp := response{idx, s.fib.Get(idx).String()}
 		responseEncoder := json.NewEncoder(w)
 		if err := responseEncoder.Encode(resp); err != nil {
@@ -73,6 +102,83 @@ func (s *server) handleSetPrevious() http.HandlerFunc {
 	}
 }
 
+// handler for jumping the sequence index directly to a requested value,
+// used by the browser UI's "advance to N" control
+func (s *server) handleSeek() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		idxVal, err := strconv.ParseUint(r.URL.Query().Get("index"), 10, 32)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid 'index' parameter: %v", err), http.StatusBadRequest)
+			return
+		}
+		idx := uint32(idxVal)
+		s.mu.Lock()
+		atomic.StoreUint32(&s.currentIndex, idx)
+		s.appendAndPublish(wal.OpSeek, idx)
+		s.mu.Unlock()
+		resp := response{idx, s.fib.Get(idx).String()}
+		responseEncoder := json.NewEncoder(w)
+		if err := responseEncoder.Encode(resp); err != nil {
+			http.Error(w, "Server error", http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+// appendAndPublish records a mutation in the WAL and fans it out to any
+// connected replication followers. A WAL error is logged but doesn't fail
+// the request - durability is batched, not synchronous with every request.
+func (s *server) appendAndPublish(op wal.Op, idx uint32) {
+	seq, err := s.wl.Append(op, idx)
+	if err != nil {
+		log.Printf("Failed to append WAL record: %v\n", err)
+		return
+	}
+	s.replHub.publish(wal.Record{Op: op, Index: idx, Seq: seq})
+}
+
+// handler for requests for a contiguous span of the sequence
+func (s *server) handleGetRange() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		from, to, err := parseRange(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		values, err := s.fib.GetRange(from, to)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		resp := make([]response, len(values))
+		for i, v := range values {
+			resp[i] = response{from + uint32(i), v.String()}
+		}
+		responseEncoder := json.NewEncoder(w)
+		if err := responseEncoder.Encode(resp); err != nil {
+			http.Error(w, "Server error", http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+// parseRange reads and validates the from/to query parameters for /range
+func parseRange(q url.Values) (from, to uint32, err error) {
+	fromVal, err := strconv.ParseUint(q.Get("from"), 10, 32)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid 'from' parameter: %w", err)
+	}
+	toVal, err := strconv.ParseUint(q.Get("to"), 10, 32)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid 'to' parameter: %w", err)
+	}
+	if fromVal > toVal {
+		return 0, 0, fmt.Errorf("'from' must be <= 'to'")
+	}
+	return uint32(fromVal), uint32(toVal), nil
+}
+
 // handler for getting cache hit/miss numbers
 func (s *server) handleGetCacheStats() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -89,8 +195,13 @@ func (s *server) makeRouter() http.Handler {
 	router := http.NewServeMux()
 
 	router.HandleFunc("/current", s.handleGetCurrent())
-	router.HandleFunc("/next", s.handleSetNext())
-	router.HandleFunc("/previous", s.handleSetPrevious())
+	router.HandleFunc("/range", s.handleGetRange())
+	if !s.follower {
+		router.HandleFunc("/next", s.handleSetNext())
+		router.HandleFunc("/previous", s.handleSetPrevious())
+		router.HandleFunc("/seek", s.handleSeek())
+		router.HandleFunc("/replicate", s.handleReplicate())
+	}
 
 	if s.debug {
 		router.HandleFunc("/debug/cache", s.handleGetCacheStats())
@@ -104,84 +215,118 @@ func (s *server) makeRouter() http.Handler {
 		router.Handle("/debug/pprof/threadcreate", pprof.Handler("threadcreate"))
 		router.Handle("/debug/pprof/block", pprof.Handler("block"))
 	}
+
+	if s.browser {
+		s.registerBrowserRoutes(router)
+	}
 	return router
 }
 
-// logs current index on a timer - panics if it fails multiple times
-func (s *server) logCurrentIndex(seconds time.Duration) {
-	ticker := time.NewTicker(seconds)
-	bs := make([]byte, 4)
-	remainingFails := 3
-	for {
-		<-ticker.C
-		binary.LittleEndian.PutUint32(bs, s.currentIndex)
-		_, err := s.backup.WriteAt(bs, 0)
-		if err != nil {
-			remainingFails -= 1
-			if remainingFails == 0 {
-				log.Fatalf("Failed to write backup: %v (exiting)\n", err)
-			}
-			log.Printf("Failed to write backup: %v (%v fails remaining)\n", err, remainingFails)
+// saveSnapshotPeriodically saves a cache snapshot to path every interval
+// until the process exits. Errors are logged rather than fatal - a failed
+// snapshot just means the next startup falls back to rebuilding the cache.
+func saveSnapshotPeriodically(fib *FibTracker, path string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if err := fib.SaveSnapshot(path); err != nil {
+			log.Printf("Failed to save cache snapshot to %q: %v\n", path, err)
 		}
 	}
 }
 
 func main() {
 	var port *uint = flag.Uint("port", 80, "port on which to expose the API")
-	var backupPath *string = flag.String("file", "fibapi_backup", "file to journal sequence index to")
-	var backupSeconds *uint = flag.Uint("seconds", 3, "seconds between each backup")
+	var walDir *string = flag.String("wal-dir", "fibapi_wal", "directory for the write-ahead log")
+	var replayOnly *bool = flag.Bool("replay-only", false, "replay the WAL to recover the sequence index, print it, and exit without serving")
+	var followerAddr *string = flag.String("follower", "", "host:port of a leader to replicate from; runs this instance read-only")
+	var algo *string = flag.String("algo", "linear", "fibonacci computation algorithm: linear|fastdoubling|auto")
+	var browser *bool = flag.Bool("browser", false, "serve an embedded browser UI at / for navigating the sequence and inspecting the cache")
+	var snapshotFile *string = flag.String("snapshot-file", "fibapi_cache_snapshot", "path to the cache snapshot used for warm-starting the cache")
+	var snapshotSeconds *uint = flag.Uint("snapshot-seconds", 0, "if > 0, save a cache snapshot to --snapshot-file this often")
+	var rebuildCache *bool = flag.Bool("rebuild-cache", false, "ignore any existing --snapshot-file and rebuild the cache from scratch")
+	var maxRangeSpan *uint = flag.Uint("max-range-span", 100000, "maximum number of indices a single /range request may span; 0 means unbounded")
+	var debug *bool = flag.Bool("debug", false, "expose /debug/cache, /debug/pprof/*, and (with --browser) the cache-inspection routes")
 	flag.Parse()
 
-	// create fibonacci tracker
-	hc, err := MakeSliceCache(100000)
+	fibAlgo, err := ParseAlgo(*algo)
 	if err != nil {
 		log.Fatal(err)
 	}
-	fib := MakeFibTracker(10, hc)
 
-	// setup the backup file
-	var backupFile *os.File
-	_, err = os.Stat(*backupPath)
-	if os.IsNotExist(err) {
-		backupFile, err = os.Create(*backupPath)
-		defer backupFile.Close()
+	if *replayOnly {
+		idx, seq, found, err := wal.Replay(*walDir)
 		if err != nil {
 			log.Fatal(err)
 		}
+		if !found {
+			fmt.Println("WAL is empty; sequence index would start at 0")
+			return
+		}
+		fmt.Printf("Recovered sequence index %v (seq %v)\n", idx, seq)
+		return
+	}
+
+	// create fibonacci tracker
+	hc, err := MakeSliceCache(100000)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fib := MakeFibTracker(10, hc).WithAlgo(fibAlgo).WithMaxRangeSpan(uint32(*maxRangeSpan))
+
+	// open the write-ahead log and replay it to recover the sequence index
+	wl, err := wal.Open(wal.Options{Dir: *walDir})
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer wl.Close()
+
+	isFollower := *followerAddr != ""
+	fibServer := makeServer(fib, wl, *walDir, *debug, *browser, isFollower)
+
+	startIdx, _, found, err := wal.Replay(*walDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if found {
+		fibServer.currentIndex = startIdx
+		log.Printf("Starting sequence index at %v\n", startIdx)
 	} else {
-		backupFile, err = os.OpenFile(*backupPath, os.O_RDWR, os.ModePerm)
-		defer backupFile.Close()
+		log.Println("Starting sequence index at zero")
+	}
+
+	loadedSnapshot := false
+	if !*rebuildCache {
+		loadedSnapshot, err = fib.LoadSnapshot(*snapshotFile)
 		if err != nil {
-			log.Fatal(err)
+			log.Printf("Failed to load cache snapshot %q: %v\n", *snapshotFile, err)
+		} else if loadedSnapshot {
+			log.Printf("Loaded cache snapshot from %q\n", *snapshotFile)
 		}
 	}
+	if !loadedSnapshot {
+		fib.WithInitializedStore(fibServer.currentIndex)
+	}
+
+	if *snapshotSeconds > 0 {
+		go saveSnapshotPeriodically(fib, *snapshotFile, time.Duration(*snapshotSeconds)*time.Second)
+	}
+
+	if isFollower {
+		go followLeader(fibServer, *followerAddr)
+	}
 
-	// create the server and routes
-	fibServer := makeServer(fib, backupFile, true)
 	router := fibServer.makeRouter()
 	address := fmt.Sprintf(":%d", *port)
 
 	httpServer := &http.Server{
-		Addr:         address,
-		ReadTimeout:  10 * time.Second,
-		WriteTimeout: 10 * time.Second,
-		Handler:      router,
+		Addr:        address,
+		ReadTimeout: 10 * time.Second,
+		// WriteTimeout is unset: /replicate holds its response open
+		// indefinitely to stream WAL records to followers.
+		Handler: router,
 	}
 
-	// set starting index
-	bs := make([]byte, 4)
-	n, err := fibServer.backup.Read(bs)
-	if err != nil || n != 4 {
-		log.Printf("Failed reading backup: %v\n", err)
-		log.Println("Starting sequence index at zero")
-	} else {
-		fibServer.currentIndex = binary.LittleEndian.Uint32(bs)
-		log.Printf("Starting sequence index at %v\n", fibServer.currentIndex)
-	}
-
-	// start logger and server
-	go fibServer.logCurrentIndex(time.Duration(*backupSeconds) * time.Second)
-
 	log.Printf("Serving at %v\n", address)
 	log.Fatal(httpServer.ListenAndServe())
 }
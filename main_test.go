@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/macintoshpie/fibapi/wal"
+)
+
+// TestConcurrentNextMatchesWALOrder fires many concurrent /next requests and
+// checks that the WAL's last (highest-seq) record holds the same index as
+// the final currentIndex - ie that the currentIndex bump and its WAL append
+// can never be reordered relative to another request's.
+func TestConcurrentNextMatchesWALOrder(t *testing.T) {
+	dir := t.TempDir()
+	wl, err := wal.Open(wal.Options{Dir: dir})
+	if err != nil {
+		t.Fatalf("wal.Open: %v", err)
+	}
+	defer wl.Close()
+
+	hc, err := MakeSliceCache(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fib := MakeFibTracker(10, hc)
+	s := makeServer(fib, wl, dir, false, false, false)
+	handler := s.handleSetNext()
+
+	const n = 200
+	var wg sync.WaitGroup
+	wg.Add(n)
+	for i := 0; i < n; i++ {
+		go func() {
+			defer wg.Done()
+			req := httptest.NewRequest(http.MethodGet, "/next", nil)
+			handler(httptest.NewRecorder(), req)
+		}()
+	}
+	wg.Wait()
+
+	finalIndex := s.currentIndex
+	if finalIndex != n {
+		t.Fatalf("expected currentIndex %v after %v /next calls, got %v", n, n, finalIndex)
+	}
+	if err := wl.Sync(); err != nil {
+		t.Fatalf("wl.Sync: %v", err)
+	}
+
+	recoveredIndex, _, found, err := wal.Replay(dir)
+	if err != nil {
+		t.Fatalf("wal.Replay: %v", err)
+	}
+	if !found {
+		t.Fatal("expected WAL to have recorded at least one record")
+	}
+	if recoveredIndex != finalIndex {
+		t.Fatalf("WAL's last record holds index %v but currentIndex is %v - append order diverged from index order", recoveredIndex, finalIndex)
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/macintoshpie/fibapi/wal"
+)
+
+// replicationHub fans out newly appended WAL records to connected followers
+// as they happen, so a follower streaming /replicate doesn't have to poll
+// the log.
+type replicationHub struct {
+	mu   sync.Mutex
+	subs map[chan wal.Record]struct{}
+}
+
+func newReplicationHub() *replicationHub {
+	return &replicationHub{subs: make(map[chan wal.Record]struct{})}
+}
+
+func (h *replicationHub) subscribe() chan wal.Record {
+	ch := make(chan wal.Record, 64)
+	h.mu.Lock()
+	h.subs[ch] = struct{}{}
+	h.mu.Unlock()
+	return ch
+}
+
+func (h *replicationHub) unsubscribe(ch chan wal.Record) {
+	h.mu.Lock()
+	delete(h.subs, ch)
+	h.mu.Unlock()
+	close(ch)
+}
+
+// publish notifies subscribers of a new record. A slow follower has its
+// record dropped rather than blocking the leader; it will catch up on
+// reconnect via the ?after= backfill in handleReplicate.
+func (h *replicationHub) publish(rec wal.Record) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subs {
+		select {
+		case ch <- rec:
+		default:
+		}
+	}
+}
+
+// handleReplicate streams WAL records to a follower as newline-delimited
+// JSON. A follower passes ?after=<seq> to resume from where it left off,
+// or omits it on first attach to mean "from the beginning"; the handler
+// first backfills from the on-disk log, then switches to live records
+// published as they're appended.
+func (s *server) handleReplicate() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+			return
+		}
+
+		afterSeq := int64(-1)
+		if v := r.URL.Query().Get("after"); v != "" {
+			fmt.Sscanf(v, "%d", &afterSeq)
+		}
+
+		// subscribe before backfilling so no record published during the
+		// backfill is missed
+		ch := s.replHub.subscribe()
+		defer s.replHub.unsubscribe(ch)
+
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.WriteHeader(http.StatusOK)
+		enc := json.NewEncoder(w)
+
+		err := wal.ReplayFrom(s.walDir, afterSeq, func(rec wal.Record) error {
+			if err := enc.Encode(rec); err != nil {
+				return err
+			}
+			afterSeq = int64(rec.Seq)
+			return nil
+		})
+		if err != nil {
+			return
+		}
+		flusher.Flush()
+
+		for {
+			select {
+			case rec, ok := <-ch:
+				if !ok {
+					return
+				}
+				if int64(rec.Seq) <= afterSeq {
+					continue
+				}
+				if err := enc.Encode(rec); err != nil {
+					return
+				}
+				afterSeq = int64(rec.Seq)
+				flusher.Flush()
+			case <-r.Context().Done():
+				return
+			}
+		}
+	}
+}
+
+// followLeader connects to a leader's /replicate endpoint and applies
+// records as they arrive, reconnecting with backoff if the connection
+// drops. It keeps s.currentIndex and the FibTracker cache warm so this
+// instance can serve /current without recomputing.
+func followLeader(s *server, leaderAddr string) {
+	lastSeq := int64(-1)
+	for {
+		if err := streamFromLeader(s, leaderAddr, lastSeq, &lastSeq); err != nil {
+			log.Printf("follower: lost connection to leader %v: %v (retrying)\n", leaderAddr, err)
+			time.Sleep(time.Second)
+		}
+	}
+}
+
+func streamFromLeader(s *server, leaderAddr string, afterSeq int64, lastSeq *int64) error {
+	url := fmt.Sprintf("http://%s/replicate?after=%d", leaderAddr, afterSeq)
+	resp, err := http.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("leader returned status %v", resp.StatusCode)
+	}
+
+	dec := json.NewDecoder(bufio.NewReader(resp.Body))
+	for {
+		var rec wal.Record
+		if err := dec.Decode(&rec); err != nil {
+			return err
+		}
+		atomic.StoreUint32(&s.currentIndex, rec.Index)
+		s.fib.Get(rec.Index) // warm the cache at the new index
+		*lastSeq = int64(rec.Seq)
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math/big"
+	"os"
+	"path/filepath"
+)
+
+// SaveSnapshot atomically writes a point-in-time copy of the cache to
+// path: cachePad, then whatever fibCache.SaveSnapshot serializes, with a
+// CRC over both so LoadSnapshot can detect a corrupt or torn write. The
+// write goes to a temp file in the same directory and is renamed into
+// place so a crash mid-write never leaves a half-written snapshot at path.
+func (fib *FibTracker) SaveSnapshot(path string) error {
+	var buf bytes.Buffer
+	if err := binary.Write(&buf, binary.LittleEndian, fib.cachePad); err != nil {
+		return fmt.Errorf("save cache snapshot: %w", err)
+	}
+	if err := fib.cache.SaveSnapshot(&buf); err != nil {
+		return fmt.Errorf("save cache snapshot: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("save cache snapshot: %w", err)
+	}
+	defer os.Remove(tmp.Name()) // no-op once the rename below succeeds
+
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		tmp.Close()
+		return fmt.Errorf("save cache snapshot: %w", err)
+	}
+	crc := crc32.ChecksumIEEE(buf.Bytes())
+	if err := binary.Write(tmp, binary.LittleEndian, crc); err != nil {
+		tmp.Close()
+		return fmt.Errorf("save cache snapshot: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("save cache snapshot: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("save cache snapshot: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("save cache snapshot: %w", err)
+	}
+	return nil
+}
+
+// LoadSnapshot loads a snapshot written by SaveSnapshot from path into the
+// cache. It reports ok=false, with no error, when there's nothing usable
+// to load: the file doesn't exist yet, or its cachePad no longer matches
+// this tracker's - a resized cache can't reuse a differently-padded
+// snapshot.
+func (fib *FibTracker) LoadSnapshot(path string) (ok bool, err error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("load cache snapshot: %w", err)
+	}
+	if len(data) < 8 {
+		return false, fmt.Errorf("load cache snapshot: truncated file")
+	}
+
+	body, crcBytes := data[:len(data)-4], data[len(data)-4:]
+	if crc32.ChecksumIEEE(body) != binary.LittleEndian.Uint32(crcBytes) {
+		return false, fmt.Errorf("load cache snapshot: CRC mismatch, snapshot is corrupt")
+	}
+
+	pad := binary.LittleEndian.Uint32(body[:4])
+	if pad != fib.cachePad {
+		return false, nil
+	}
+	if err := fib.cache.LoadSnapshot(bytes.NewReader(body[4:])); err != nil {
+		return false, fmt.Errorf("load cache snapshot: %w", err)
+	}
+	return true, nil
+}
+
+// SaveSnapshot serializes every slot - the cache's length, then each
+// entry's idx and pair.i/pair.j via big.Int.GobEncode - to w.
+func (c *sliceCache) SaveSnapshot(w io.Writer) error {
+	scMux.Lock()
+	defer scMux.Unlock()
+
+	if err := binary.Write(w, binary.LittleEndian, uint32(len(*c))); err != nil {
+		return err
+	}
+	for _, e := range *c {
+		if err := binary.Write(w, binary.LittleEndian, e.idx); err != nil {
+			return err
+		}
+		if err := writeGobBigInt(w, e.pair.i); err != nil {
+			return err
+		}
+		if err := writeGobBigInt(w, e.pair.j); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LoadSnapshot replaces every slot with what SaveSnapshot wrote to r. The
+// serialized length must match this cache's size - a snapshot from a
+// differently-sized cache can't be mapped onto this one's slots.
+func (c *sliceCache) LoadSnapshot(r io.Reader) error {
+	scMux.Lock()
+	defer scMux.Unlock()
+
+	var length uint32
+	if err := binary.Read(r, binary.LittleEndian, &length); err != nil {
+		return err
+	}
+	if int(length) != len(*c) {
+		return fmt.Errorf("snapshot has %v slots, cache has %v", length, len(*c))
+	}
+
+	entries := make([]scEntry, length)
+	for i := range entries {
+		var idx uint32
+		if err := binary.Read(r, binary.LittleEndian, &idx); err != nil {
+			return err
+		}
+		fi, err := readGobBigInt(r)
+		if err != nil {
+			return err
+		}
+		fj, err := readGobBigInt(r)
+		if err != nil {
+			return err
+		}
+		entries[i] = scEntry{idx: idx, pair: fibPair{fi, fj}}
+	}
+	copy(*c, entries)
+	return nil
+}
+
+// writeGobBigInt writes n's GobEncode output to w, length-prefixed since
+// the encoded size varies with n's magnitude.
+func writeGobBigInt(w io.Writer, n *big.Int) error {
+	data, err := n.GobEncode()
+	if err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.LittleEndian, uint32(len(data))); err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// readGobBigInt reads a big.Int written by writeGobBigInt.
+func readGobBigInt(r io.Reader) (*big.Int, error) {
+	var length uint32
+	if err := binary.Read(r, binary.LittleEndian, &length); err != nil {
+		return nil, err
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+	n := new(big.Int)
+	if err := n.GobDecode(data); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
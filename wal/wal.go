@@ -0,0 +1,449 @@
+// Package wal implements a small segmented, append-only write-ahead log
+// used to make the fibapi sequence index durable across restarts and to
+// feed leader/follower replication.
+package wal
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Op identifies the kind of mutation a Record represents.
+type Op uint8
+
+const (
+	// OpNext records that the sequence index was advanced to Index.
+	OpNext Op = iota + 1
+	// OpPrevious records that the sequence index was decremented to Index.
+	OpPrevious
+	// OpSeek records that the sequence index was set directly to Index.
+	OpSeek
+)
+
+// Record is a single WAL entry: an op, the resulting index, and a
+// monotonic sequence number used to order records across segments and to
+// resume replication streams.
+type Record struct {
+	Op    Op
+	Index uint32
+	Seq   uint64
+}
+
+// recordSize is the on-disk size of a Record: op(1) + index(4) + seq(8) + crc32(4).
+const recordSize = 1 + 4 + 8 + 4
+
+// DefaultMaxSegmentBytes is the segment size at which the log rotates to a
+// new segment file.
+const DefaultMaxSegmentBytes = 16 * 1024 * 1024
+
+// DefaultSyncInterval is how often buffered records are flushed and fsynced
+// when batching is in effect. Smaller values bound the amount of data that
+// can be lost on crash at the cost of more fsyncs.
+const DefaultSyncInterval = 50 * time.Millisecond
+
+const segmentExt = ".wal"
+
+// Options configures Open.
+type Options struct {
+	// Dir is the directory holding segment files. It is created if missing.
+	Dir string
+	// MaxSegmentBytes rotates to a new segment once the current one would
+	// exceed this size. Zero uses DefaultMaxSegmentBytes.
+	MaxSegmentBytes int64
+	// SyncInterval batches fsyncs on this interval instead of syncing every
+	// Append. Zero uses DefaultSyncInterval; negative disables batching and
+	// syncs on every Append.
+	SyncInterval time.Duration
+}
+
+// WAL is a segmented append-only log of Records.
+type WAL struct {
+	dir             string
+	maxSegmentBytes int64
+	syncInterval    time.Duration
+
+	mu       sync.Mutex
+	cur      *os.File
+	curBase  uint64 // first seq stored in the current segment
+	curBytes int64
+	bufw     *bufio.Writer
+	nextSeq  uint64
+	dirty    bool
+	closed   bool
+
+	syncDone chan struct{}
+	syncStop chan struct{}
+}
+
+// Open opens (creating if necessary) the segmented log rooted at opts.Dir,
+// replaying existing segments to recover nextSeq and truncating any torn
+// trailing write left by a crash.
+func Open(opts Options) (*WAL, error) {
+	if opts.Dir == "" {
+		return nil, fmt.Errorf("wal: Dir must not be empty")
+	}
+	if err := os.MkdirAll(opts.Dir, 0o755); err != nil {
+		return nil, fmt.Errorf("wal: create dir: %w", err)
+	}
+	maxSegmentBytes := opts.MaxSegmentBytes
+	if maxSegmentBytes <= 0 {
+		maxSegmentBytes = DefaultMaxSegmentBytes
+	}
+	syncInterval := opts.SyncInterval
+	if syncInterval == 0 {
+		syncInterval = DefaultSyncInterval
+	}
+
+	segments, err := listSegments(opts.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var lastRecord *Record
+	for _, seg := range segments {
+		n, last, err := truncateToLastValidRecord(seg.path)
+		if err != nil {
+			return nil, fmt.Errorf("wal: recover segment %v: %w", seg.path, err)
+		}
+		if last != nil {
+			lastRecord = last
+		}
+		_ = n
+	}
+
+	w := &WAL{
+		dir:             opts.Dir,
+		maxSegmentBytes: maxSegmentBytes,
+		syncInterval:    syncInterval,
+		syncDone:        make(chan struct{}),
+		syncStop:        make(chan struct{}),
+	}
+	if lastRecord != nil {
+		w.nextSeq = lastRecord.Seq + 1
+	}
+
+	if len(segments) == 0 {
+		if err := w.openSegment(0); err != nil {
+			return nil, err
+		}
+	} else {
+		last := segments[len(segments)-1]
+		if err := w.openExistingSegment(last); err != nil {
+			return nil, err
+		}
+	}
+
+	if syncInterval > 0 {
+		go w.syncLoop()
+	}
+	return w, nil
+}
+
+type segmentFile struct {
+	base uint64
+	path string
+}
+
+func listSegments(dir string) ([]segmentFile, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("wal: list segments: %w", err)
+	}
+	var segments []segmentFile
+	for _, e := range entries {
+		if e.IsDir() || filepath.Ext(e.Name()) != segmentExt {
+			continue
+		}
+		var base uint64
+		if _, err := fmt.Sscanf(e.Name(), "%020d"+segmentExt, &base); err != nil {
+			continue
+		}
+		segments = append(segments, segmentFile{base: base, path: filepath.Join(dir, e.Name())})
+	}
+	sort.Slice(segments, func(i, j int) bool { return segments[i].base < segments[j].base })
+	return segments, nil
+}
+
+func segmentName(dir string, base uint64) string {
+	return filepath.Join(dir, fmt.Sprintf("%020d%s", base, segmentExt))
+}
+
+func (w *WAL) openSegment(base uint64) error {
+	f, err := os.OpenFile(segmentName(w.dir, base), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("wal: open segment: %w", err)
+	}
+	w.cur = f
+	w.curBase = base
+	w.curBytes = 0
+	w.bufw = bufio.NewWriter(f)
+	return nil
+}
+
+func (w *WAL) openExistingSegment(seg segmentFile) error {
+	f, err := os.OpenFile(seg.path, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("wal: open segment: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("wal: stat segment: %w", err)
+	}
+	w.cur = f
+	w.curBase = seg.base
+	w.curBytes = info.Size()
+	w.bufw = bufio.NewWriter(f)
+	return nil
+}
+
+// truncateToLastValidRecord reads seg from the start, returns the last
+// record successfully decoded, and truncates any trailing bytes that don't
+// form a complete, CRC-valid record (a torn write left by a crash).
+func truncateToLastValidRecord(path string) (validBytes int64, last *Record, err error) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0o644)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer f.Close()
+
+	buf := make([]byte, recordSize)
+	var offset int64
+	for {
+		n, readErr := io.ReadFull(f, buf)
+		if readErr == io.EOF {
+			break
+		}
+		if readErr == io.ErrUnexpectedEOF || n < recordSize {
+			// torn write: fewer bytes than a full record remain
+			break
+		}
+		if readErr != nil {
+			return 0, nil, readErr
+		}
+		rec, ok := decodeRecord(buf)
+		if !ok {
+			// CRC mismatch: corrupted/torn record, stop here
+			break
+		}
+		offset += recordSize
+		last = &rec
+	}
+	if err := f.Truncate(offset); err != nil {
+		return 0, nil, err
+	}
+	return offset, last, nil
+}
+
+func encodeRecord(rec Record) []byte {
+	buf := make([]byte, recordSize)
+	buf[0] = byte(rec.Op)
+	binary.LittleEndian.PutUint32(buf[1:5], rec.Index)
+	binary.LittleEndian.PutUint64(buf[5:13], rec.Seq)
+	crc := crc32.ChecksumIEEE(buf[:13])
+	binary.LittleEndian.PutUint32(buf[13:17], crc)
+	return buf
+}
+
+func decodeRecord(buf []byte) (Record, bool) {
+	crc := crc32.ChecksumIEEE(buf[:13])
+	if binary.LittleEndian.Uint32(buf[13:17]) != crc {
+		return Record{}, false
+	}
+	return Record{
+		Op:    Op(buf[0]),
+		Index: binary.LittleEndian.Uint32(buf[1:5]),
+		Seq:   binary.LittleEndian.Uint64(buf[5:13]),
+	}, true
+}
+
+// Append writes a record for op/index, assigning it the next sequence
+// number. The record is buffered and, depending on SyncInterval, fsynced
+// either immediately or on the next batched sync.
+func (w *WAL) Append(op Op, index uint32) (uint64, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return 0, fmt.Errorf("wal: append on closed log")
+	}
+
+	if w.curBytes+recordSize > w.maxSegmentBytes && w.curBytes > 0 {
+		if err := w.rotateLocked(); err != nil {
+			return 0, err
+		}
+	}
+
+	seq := w.nextSeq
+	rec := Record{Op: op, Index: index, Seq: seq}
+	if _, err := w.bufw.Write(encodeRecord(rec)); err != nil {
+		return 0, fmt.Errorf("wal: append: %w", err)
+	}
+	w.curBytes += recordSize
+	w.nextSeq++
+	w.dirty = true
+
+	if w.syncInterval <= 0 {
+		if err := w.syncLocked(); err != nil {
+			return 0, err
+		}
+	}
+	return seq, nil
+}
+
+func (w *WAL) rotateLocked() error {
+	if err := w.syncLocked(); err != nil {
+		return err
+	}
+	if err := w.cur.Close(); err != nil {
+		return fmt.Errorf("wal: close segment during rotation: %w", err)
+	}
+	return w.openSegment(w.nextSeq)
+}
+
+// Sync flushes any buffered records and fsyncs the current segment.
+func (w *WAL) Sync() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.syncLocked()
+}
+
+func (w *WAL) syncLocked() error {
+	if !w.dirty {
+		return nil
+	}
+	if err := w.bufw.Flush(); err != nil {
+		return fmt.Errorf("wal: flush: %w", err)
+	}
+	if err := w.cur.Sync(); err != nil {
+		return fmt.Errorf("wal: fsync: %w", err)
+	}
+	w.dirty = false
+	return nil
+}
+
+func (w *WAL) syncLoop() {
+	ticker := time.NewTicker(w.syncInterval)
+	defer ticker.Stop()
+	defer close(w.syncDone)
+	for {
+		select {
+		case <-ticker.C:
+			w.Sync()
+		case <-w.syncStop:
+			return
+		}
+	}
+}
+
+// Close flushes and fsyncs outstanding records and closes the current
+// segment. The log can no longer be appended to afterwards.
+func (w *WAL) Close() error {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return nil
+	}
+	w.closed = true
+	if w.syncInterval > 0 {
+		close(w.syncStop)
+	}
+	err := w.syncLocked()
+	closeErr := w.cur.Close()
+	w.mu.Unlock()
+
+	if w.syncInterval > 0 {
+		<-w.syncDone
+	}
+	if err != nil {
+		return err
+	}
+	return closeErr
+}
+
+// Replay reads every valid, committed record in dir, in order, and returns
+// the index and sequence number of the last one. It is safe to call on a
+// log that is also open for appends elsewhere in the same process only if
+// no writes are concurrently in flight; typical use is on startup before
+// Open, or with --replay-only where no writer exists.
+func Replay(dir string) (index uint32, seq uint64, found bool, err error) {
+	segments, err := listSegments(dir)
+	if err != nil {
+		return 0, 0, false, err
+	}
+	for _, seg := range segments {
+		f, err := os.Open(seg.path)
+		if err != nil {
+			return 0, 0, false, err
+		}
+		buf := make([]byte, recordSize)
+		for {
+			_, readErr := io.ReadFull(f, buf)
+			if readErr == io.EOF {
+				break
+			}
+			if readErr == io.ErrUnexpectedEOF {
+				break
+			}
+			if readErr != nil {
+				f.Close()
+				return 0, 0, false, readErr
+			}
+			rec, ok := decodeRecord(buf)
+			if !ok {
+				break
+			}
+			index, seq, found = rec.Index, rec.Seq, true
+		}
+		f.Close()
+	}
+	return index, seq, found, nil
+}
+
+// ReplayFrom invokes fn for every valid record in dir with Seq > afterSeq,
+// in order. It's used to prime a newly attached replication follower with
+// whatever of the log it hasn't seen yet. afterSeq is signed so a follower
+// that hasn't seen any records yet can pass -1 and receive Seq 0 onward;
+// Seq itself is always >= 0, so there's no valid uint64 sentinel for "none".
+func ReplayFrom(dir string, afterSeq int64, fn func(Record) error) error {
+	segments, err := listSegments(dir)
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, recordSize)
+	for _, seg := range segments {
+		f, err := os.Open(seg.path)
+		if err != nil {
+			return err
+		}
+		for {
+			_, readErr := io.ReadFull(f, buf)
+			if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+				break
+			}
+			if readErr != nil {
+				f.Close()
+				return readErr
+			}
+			rec, ok := decodeRecord(buf)
+			if !ok {
+				break
+			}
+			if int64(rec.Seq) > afterSeq {
+				if err := fn(rec); err != nil {
+					f.Close()
+					return err
+				}
+			}
+		}
+		f.Close()
+	}
+	return nil
+}
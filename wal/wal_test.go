@@ -0,0 +1,313 @@
+package wal
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writerSubprocessEnvVar activates writer-subprocess mode (see
+// runCrashWriter) in place of the normal test suite, so
+// TestCrashKillsRealProcessMidWrite can SIGKILL a genuine OS process rather
+// than only simulating a crash in-process.
+const writerSubprocessEnvVar = "FIBAPI_WAL_CRASH_WRITER_DIR"
+
+func TestMain(m *testing.M) {
+	if dir := os.Getenv(writerSubprocessEnvVar); dir != "" {
+		runCrashWriter(dir)
+		return
+	}
+	os.Exit(m.Run())
+}
+
+// runCrashWriter appends sequentially, syncing after every record and
+// reporting each synced index on stdout, until the parent test kills it.
+func runCrashWriter(dir string) {
+	w, err := Open(Options{Dir: dir, SyncInterval: -1})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "writer: Open: %v\n", err)
+		os.Exit(1)
+	}
+	for i := uint32(1); ; i++ {
+		if _, err := w.Append(OpNext, i); err != nil {
+			fmt.Fprintf(os.Stderr, "writer: Append: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("synced %v\n", i)
+	}
+}
+
+// openForTest opens a WAL with syncing disabled (sync on every Append) so
+// tests can reason precisely about what's durable at any point.
+func openForTest(t *testing.T, dir string) *WAL {
+	t.Helper()
+	w, err := Open(Options{Dir: dir, SyncInterval: -1})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	return w
+}
+
+func TestAppendAndReplay(t *testing.T) {
+	dir := t.TempDir()
+	w := openForTest(t, dir)
+
+	for i := uint32(1); i <= 5; i++ {
+		if _, err := w.Append(OpNext, i); err != nil {
+			t.Fatalf("Append: %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	idx, seq, found, err := Replay(dir)
+	if err != nil {
+		t.Fatalf("Replay: %v", err)
+	}
+	if !found {
+		t.Fatal("expected a record to be found")
+	}
+	if idx != 5 {
+		t.Fatalf("expected index 5, got %v", idx)
+	}
+	if seq != 4 {
+		t.Fatalf("expected seq 4 (0-indexed), got %v", seq)
+	}
+}
+
+func TestReopenResumesSequence(t *testing.T) {
+	dir := t.TempDir()
+	w := openForTest(t, dir)
+	for i := uint32(1); i <= 3; i++ {
+		w.Append(OpNext, i)
+	}
+	w.Close()
+
+	w2 := openForTest(t, dir)
+	seq, err := w2.Append(OpNext, 4)
+	if err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+	if seq != 3 {
+		t.Fatalf("expected next seq 3, got %v", seq)
+	}
+	w2.Close()
+}
+
+// TestCrashLosesOnlyUnsyncedRecords simulates a process that dies before its
+// batched writes are flushed: records appended after the last Sync must not
+// survive, but nothing committed by Sync is lost.
+func TestCrashLosesOnlyUnsyncedRecords(t *testing.T) {
+	dir := t.TempDir()
+	w, err := Open(Options{Dir: dir, SyncInterval: time.Hour}) // never fires on its own
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+
+	for i := uint32(1); i <= 3; i++ {
+		w.Append(OpNext, i)
+	}
+	if err := w.Sync(); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	for i := uint32(4); i <= 6; i++ {
+		w.Append(OpNext, i)
+	}
+	// simulate a crash: no Close, so the buffered (unsynced) writes for
+	// indices 4-6 never reach disk.
+	close(w.syncStop)
+
+	idx, seq, found, err := Replay(dir)
+	if err != nil {
+		t.Fatalf("Replay: %v", err)
+	}
+	if !found {
+		t.Fatal("expected committed records to survive the crash")
+	}
+	if idx != 3 {
+		t.Fatalf("expected only the synced index 3 to survive, got %v", idx)
+	}
+	if seq != 2 {
+		t.Fatalf("expected seq 2, got %v", seq)
+	}
+}
+
+// TestOpenTruncatesTornWrite simulates a crash mid-write at the OS level: a
+// partial record is appended directly to the segment file, bypassing the
+// WAL. Open must discard it and recover only the complete, valid records.
+func TestOpenTruncatesTornWrite(t *testing.T) {
+	dir := t.TempDir()
+	w := openForTest(t, dir)
+	for i := uint32(1); i <= 2; i++ {
+		w.Append(OpNext, i)
+	}
+	w.Close()
+
+	segments, err := listSegments(dir)
+	if err != nil || len(segments) != 1 {
+		t.Fatalf("expected exactly one segment, got %v (err %v)", segments, err)
+	}
+	f, err := os.OpenFile(segments[0].path, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		t.Fatalf("open segment: %v", err)
+	}
+	if _, err := f.Write([]byte{0x01, 0x02, 0x03}); err != nil {
+		t.Fatalf("write torn bytes: %v", err)
+	}
+	f.Close()
+
+	w2 := openForTest(t, dir)
+	defer w2.Close()
+
+	idx, seq, found, err := Replay(dir)
+	if err != nil {
+		t.Fatalf("Replay: %v", err)
+	}
+	if !found || idx != 2 || seq != 1 {
+		t.Fatalf("expected recovery of index 2 seq 1, got idx=%v seq=%v found=%v", idx, seq, found)
+	}
+
+	// the torn bytes must have been truncated away so future appends don't
+	// leave garbage in the middle of the segment.
+	info, err := os.Stat(segments[0].path)
+	if err != nil {
+		t.Fatalf("stat: %v", err)
+	}
+	if info.Size() != 2*recordSize {
+		t.Fatalf("expected segment truncated to %v bytes, got %v", 2*recordSize, info.Size())
+	}
+}
+
+// TestCrashKillsRealProcessMidWrite forks a real writer subprocess (see
+// runCrashWriter), lets it append and sync a batch of records, then
+// SIGKILLs it mid-stream and replays the log - verifying that no record
+// the writer reported as synced is lost. Unlike
+// TestCrashLosesOnlyUnsyncedRecords, which simulates a crash in-process,
+// this exercises the segment-truncation/recovery path against a genuine
+// OS-level process kill.
+func TestCrashKillsRealProcessMidWrite(t *testing.T) {
+	dir := t.TempDir()
+
+	cmd := exec.Command(os.Args[0])
+	cmd.Env = append(os.Environ(), writerSubprocessEnvVar+"="+dir)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatalf("StdoutPipe: %v", err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+
+	const wantSynced = 20
+	var lastSynced uint32
+	scanner := bufio.NewScanner(stdout)
+	for lastSynced < wantSynced && scanner.Scan() {
+		var idx uint32
+		if _, err := fmt.Sscanf(scanner.Text(), "synced %d", &idx); err == nil {
+			lastSynced = idx
+		}
+	}
+	if lastSynced == 0 {
+		t.Fatal("writer subprocess never reported a synced record")
+	}
+
+	if err := cmd.Process.Kill(); err != nil {
+		t.Fatalf("Kill: %v", err)
+	}
+	cmd.Wait() // expected to report the kill as an error; nothing to check
+
+	idx, _, found, err := Replay(dir)
+	if err != nil {
+		t.Fatalf("Replay: %v", err)
+	}
+	if !found {
+		t.Fatalf("expected the %v records synced before the kill to survive", lastSynced)
+	}
+	if idx < lastSynced {
+		t.Fatalf("lost committed records: writer synced up to %v but replay only recovered %v", lastSynced, idx)
+	}
+}
+
+func TestRotation(t *testing.T) {
+	dir := t.TempDir()
+	w, err := Open(Options{Dir: dir, MaxSegmentBytes: recordSize * 2, SyncInterval: -1})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	for i := uint32(1); i <= 5; i++ {
+		if _, err := w.Append(OpNext, i); err != nil {
+			t.Fatalf("Append: %v", err)
+		}
+	}
+	w.Close()
+
+	segments, err := listSegments(dir)
+	if err != nil {
+		t.Fatalf("listSegments: %v", err)
+	}
+	if len(segments) < 2 {
+		t.Fatalf("expected rotation to produce multiple segments, got %v", len(segments))
+	}
+
+	idx, _, found, err := Replay(dir)
+	if err != nil || !found || idx != 5 {
+		t.Fatalf("expected to recover index 5 across segments, got idx=%v found=%v err=%v", idx, found, err)
+	}
+}
+
+func TestReplayFrom(t *testing.T) {
+	dir := t.TempDir()
+	w := openForTest(t, dir)
+	for i := uint32(1); i <= 5; i++ {
+		w.Append(OpNext, i)
+	}
+	w.Close()
+
+	var got []Record
+	if err := ReplayFrom(dir, 2, func(r Record) error {
+		got = append(got, r)
+		return nil
+	}); err != nil {
+		t.Fatalf("ReplayFrom: %v", err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("expected 2 records after seq 2, got %v", len(got))
+	}
+	if got[0].Seq != 3 || got[1].Seq != 4 {
+		t.Fatalf("unexpected records: %+v", got)
+	}
+
+	var all []Record
+	if err := ReplayFrom(dir, -1, func(r Record) error {
+		all = append(all, r)
+		return nil
+	}); err != nil {
+		t.Fatalf("ReplayFrom: %v", err)
+	}
+	if len(all) != 5 || all[0].Seq != 0 {
+		t.Fatalf("expected afterSeq=-1 to include Seq 0, got %+v", all)
+	}
+}
+
+func TestSegmentNaming(t *testing.T) {
+	dir := t.TempDir()
+	w, err := Open(Options{Dir: dir, MaxSegmentBytes: recordSize, SyncInterval: -1})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	w.Append(OpNext, 1)
+	w.Append(OpNext, 2)
+	w.Close()
+
+	if _, err := os.Stat(filepath.Join(dir, "00000000000000000000.wal")); err != nil {
+		t.Fatalf("expected first segment to exist: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "00000000000000000001.wal")); err != nil {
+		t.Fatalf("expected second segment to exist: %v", err)
+	}
+}